@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// departureCandidate is one evaluated departure-time shift (and, with
+// --route-reverse, direction) for the optimize-departure subcommand.
+type departureCandidate struct {
+	departure          time.Time
+	reversed           bool
+	blindingSeconds    float64
+	deepSunSeconds     float64
+	windshieldExposure float64
+}
+
+// allPoints returns the point sequence optimize-departure works on: the
+// first track's first segment, falling back to the first route. Multi-leg
+// trips aren't this subcommand's target use case.
+func allPoints(gpxFile *gpx.GPX) []gpx.GPXPoint {
+	if len(gpxFile.Tracks) > 0 && len(gpxFile.Tracks[0].Segments) > 0 {
+		return gpxFile.Tracks[0].Segments[0].Points
+	}
+	if len(gpxFile.Routes) > 0 {
+		return gpxFile.Routes[0].Points
+	}
+	return nil
+}
+
+// timeOnDay resolves an "HH:MM" clock string to a time.Time on the same day
+// (and in the same location) as day.
+func timeOnDay(day time.Time, clock string) (time.Time, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), parsed.Hour(), parsed.Minute(), 0, 0, day.Location()), nil
+}
+
+// departureCandidateExposure re-runs the sun-impact math for points shifted
+// by delta - their geometry and inter-point durations are unchanged - and
+// returns the sums optimize-departure compares candidates on.
+func departureCandidateExposure(points []gpx.GPXPoint, headingOverrides map[int]float64, delta time.Duration, pauseDetectDuration time.Duration, blindingThreshold float64) (blindingSeconds, deepSunSeconds, windshieldExposureJoules float64) {
+	for pointIndex := 1; pointIndex < len(points); pointIndex++ {
+		prev, curr := points[pointIndex-1], points[pointIndex]
+		prev.Timestamp = prev.Timestamp.Add(delta)
+		curr.Timestamp = curr.Timestamp.Add(delta)
+
+		var headingOverride *float64
+		if override, ok := headingOverrides[pointIndex]; ok {
+			headingOverride = &override
+		}
+		metrics, ok := computePointMetrics(prev, curr, headingOverride, pauseDetectDuration, nil)
+		if !ok || metrics.sunElevation < 0 {
+			continue
+		}
+
+		if metrics.sunElevation < 15 {
+			deepSunSeconds += metrics.durationSeconds
+		}
+		windshieldExposureJoules += metrics.windshieldTotal * metrics.durationSeconds
+		if metrics.windshieldDirect > blindingThreshold {
+			blindingSeconds += metrics.durationSeconds
+		}
+	}
+	return blindingSeconds, deepSunSeconds, windshieldExposureJoules
+}
+
+// dominates reports whether candidate a Pareto-dominates b: no worse on any
+// of blinding/deep-sun/windshield-exposure, and strictly better on at least
+// one.
+func dominates(a, b departureCandidate) bool {
+	noWorse := a.blindingSeconds <= b.blindingSeconds && a.deepSunSeconds <= b.deepSunSeconds && a.windshieldExposure <= b.windshieldExposure
+	better := a.blindingSeconds < b.blindingSeconds || a.deepSunSeconds < b.deepSunSeconds || a.windshieldExposure < b.windshieldExposure
+	return noWorse && better
+}
+
+// paretoBestDeparture picks the candidate on the Pareto front (not
+// dominated by any other candidate), breaking ties between front members
+// by the least blinding-sun exposure, the headline metric.
+func paretoBestDeparture(candidates []departureCandidate) departureCandidate {
+	var best departureCandidate
+	haveBest := false
+	for _, candidate := range candidates {
+		dominated := false
+		for _, other := range candidates {
+			if dominates(other, candidate) {
+				dominated = true
+				break
+			}
+		}
+		if dominated {
+			continue
+		}
+		if !haveBest || candidate.blindingSeconds < best.blindingSeconds {
+			best = candidate
+			haveBest = true
+		}
+	}
+	return best
+}
+
+// optimizeDeparture implements the `optimize-departure` subcommand: it
+// shifts the whole trip's timestamps across a search window and reports the
+// Δt with the least blinding-sun exposure, so a driver can pick a departure
+// time without re-recording the trip.
+func optimizeDeparture(args []string) {
+	fs := flag.NewFlagSet("optimize-departure", flag.ExitOnError)
+	fromFlag := fs.String("from", "06:00", "search window start, HH:MM")
+	toFlag := fs.String("to", "10:00", "search window end, HH:MM")
+	stepFlag := fs.Duration("step", 5*time.Minute, "search step")
+	reverseFlag := fs.Bool("route-reverse", false, "also evaluate driving the track backwards")
+	blindingFlag := fs.Float64("blinding-threshold", defaultBlindingThreshold, "windshield irradiance threshold for SunBlinding, W/m^2")
+	pauseFlag := fs.Duration("pause", 10*time.Second, "pause detection duration")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Println("Usage: go run . optimize-departure [--from=06:00] [--to=10:00] [--step=5m] [--route-reverse] <example.gpx>")
+		os.Exit(0)
+	}
+	filename := fs.Arg(0)
+	baseFilename := filename[0 : len(filename)-len(filepath.Ext(filename))]
+
+	var gpxFile *gpx.GPX
+	var headingOverrides map[int]float64
+	switch detectInputFormat(filename) {
+	case "nmea":
+		var err error
+		gpxFile, headingOverrides, err = parseNMEAFile(filename)
+		check(err)
+	default:
+		payload, err := os.ReadFile(filename)
+		check(err)
+		gpxFile, err = gpx.ParseBytes(payload)
+		check(err)
+	}
+
+	points := allPoints(gpxFile)
+	if len(points) < 2 {
+		fmt.Println("not enough points to optimize a departure time")
+		return
+	}
+
+	fromTime, err := timeOnDay(points[0].Timestamp, *fromFlag)
+	check(err)
+	toTime, err := timeOnDay(points[0].Timestamp, *toFlag)
+	check(err)
+
+	csvFile, err := os.Create(baseFilename + ".departure.csv")
+	check(err)
+	csvWriter := csv.NewWriter(csvFile)
+	csvWriter.Write([]string{"departure", "reversed", "blinding seconds", "deep sun seconds", "windshield exposure Jm2"})
+
+	routeVariants := []struct {
+		points   []gpx.GPXPoint
+		reversed bool
+	}{{points, false}}
+	if *reverseFlag {
+		reversed := make([]gpx.GPXPoint, len(points))
+		for i := range points {
+			reversed[i] = points[len(points)-1-i]
+		}
+		// reversing the slice alone leaves each point's original timestamp
+		// attached, so consecutive points would run backwards in time;
+		// rebuild timestamps from the same gaps, traversed in the new order,
+		// so durations run forward again
+		for i := 1; i < len(reversed); i++ {
+			gap := points[len(points)-i].Timestamp.Sub(points[len(points)-i-1].Timestamp)
+			reversed[i].Timestamp = reversed[i-1].Timestamp.Add(gap)
+		}
+		routeVariants = append(routeVariants, struct {
+			points   []gpx.GPXPoint
+			reversed bool
+		}{reversed, true})
+	}
+
+	var candidates []departureCandidate
+	for _, variant := range routeVariants {
+		// a reversed run drives every leg the opposite way, so a heading
+		// override captured for the forward direction no longer applies
+		variantHeadingOverrides := headingOverrides
+		if variant.reversed {
+			variantHeadingOverrides = nil
+		}
+		for departure := fromTime; !departure.After(toTime); departure = departure.Add(*stepFlag) {
+			delta := departure.Sub(variant.points[0].Timestamp)
+			blindingSeconds, deepSunSeconds, windshieldExposureJoules := departureCandidateExposure(variant.points, variantHeadingOverrides, delta, *pauseFlag, *blindingFlag)
+
+			candidates = append(candidates, departureCandidate{
+				departure:          departure,
+				reversed:           variant.reversed,
+				blindingSeconds:    blindingSeconds,
+				deepSunSeconds:     deepSunSeconds,
+				windshieldExposure: windshieldExposureJoules,
+			})
+			csvWriter.Write([]string{
+				departure.Format("15:04:05"),
+				strconv.FormatBool(variant.reversed),
+				strconv.FormatFloat(blindingSeconds, 'f', 1, 64),
+				strconv.FormatFloat(deepSunSeconds, 'f', 1, 64),
+				strconv.FormatFloat(windshieldExposureJoules, 'f', 1, 64),
+			})
+		}
+	}
+	csvWriter.Flush()
+	csvFile.Close()
+
+	best := paretoBestDeparture(candidates)
+	direction := "forward"
+	if best.reversed {
+		direction = "reversed"
+	}
+	fmt.Printf("Pareto-best departure: %s (%s) - blinding %.1f min, deep sun %.1f min, windshield exposure %.0f kJ/m²\n",
+		best.departure.Format("15:04"), direction, best.blindingSeconds/60, best.deepSunSeconds/60, best.windshieldExposure/1000)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// shrNamespace tags the per-point sun-geometry extensions this tool writes,
+// following the NaviCtrl pattern of a rich <extensions> block per trkpt.
+const shrNamespace = "https://everydayroadster.example/gpx-sunheadinger/v1"
+
+// attachSunExtensions records this point's sun geometry and classification
+// as a <shr:...> extensions block, turning the output GPX into a
+// self-contained analysis artifact instead of just a colored polyline.
+func attachSunExtensions(point *gpx.GPXPoint, sunAzimuth, sunElevation, sunImpactAngle, carHeading float64, state SunState) {
+	fields := []struct{ name, value string }{
+		{"SunAzimuth", strconv.FormatFloat(sunAzimuth, 'f', 3, 64)},
+		{"SunElevation", strconv.FormatFloat(sunElevation, 'f', 3, 64)},
+		{"SunImpactAngle", strconv.FormatFloat(sunImpactAngle, 'f', 3, 64)},
+		{"CarHeading", strconv.FormatFloat(carHeading, 'f', 3, 64)},
+		{"SunState", state.ToString()},
+	}
+	for _, field := range fields {
+		point.Extensions.Nodes = append(point.Extensions.Nodes, gpx.ExtensionNode{
+			XMLName: xml.Name{Space: shrNamespace, Local: field.name},
+			Data:    field.value,
+		})
+	}
+}
+
+// attachTerrainExtension records the horizon elevation angle sampled along
+// the sun azimuth for this point, so users can see where a canyon or
+// mountain shielded them even on points the sun never actually reached.
+func attachTerrainExtension(point *gpx.GPXPoint, horizonAngleDegrees float64) {
+	point.Extensions.Nodes = append(point.Extensions.Nodes, gpx.ExtensionNode{
+		XMLName: xml.Name{Space: shrNamespace, Local: "HorizonAngle"},
+		Data:    strconv.FormatFloat(horizonAngleDegrees, 'f', 3, 64),
+	})
+}
+
+// encodeExtensionsAsComments folds every point's shr and nmea extension
+// fields into its <cmt>, for GPX 1.0 output where trkpt/rtept/wpt don't
+// support per-point <extensions>.
+func encodeExtensionsAsComments(gpxFile *gpx.GPX) {
+	for trackIndex := range gpxFile.Tracks {
+		for segIndex := range gpxFile.Tracks[trackIndex].Segments {
+			points := gpxFile.Tracks[trackIndex].Segments[segIndex].Points
+			for pointIndex := range points {
+				foldExtensionsIntoComment(&points[pointIndex])
+			}
+		}
+	}
+	for waypointIndex := range gpxFile.Waypoints {
+		foldExtensionsIntoComment(&gpxFile.Waypoints[waypointIndex])
+	}
+}
+
+func foldExtensionsIntoComment(point *gpx.GPXPoint) {
+	var parts []string
+	for _, node := range point.Extensions.Nodes {
+		if node.XMLName.Space != shrNamespace && node.XMLName.Space != nmeaNamespace {
+			continue
+		}
+		parts = append(parts, node.XMLName.Local+"="+node.Data)
+	}
+	if len(parts) == 0 {
+		return
+	}
+	if point.Comment != "" {
+		point.Comment += " / "
+	}
+	point.Comment += strings.Join(parts, " ")
+	point.Extensions.Nodes = nil
+}
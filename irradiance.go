@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// Solar-irradiance model used to classify sun exposure by physical energy
+// onto the windshield (W/m²) instead of by elevation/azimuth thresholds
+// alone. Loosely follows the SURFRAD direct-normal/diffuse decomposition.
+const (
+	// solarConstant is the extraterrestrial irradiance, W/m².
+	solarConstant = 1361.0
+	// diffuseFraction is the crude constant share of DNI treated as
+	// diffuse skylight reaching the windshield regardless of incidence.
+	diffuseFraction = 0.1
+	// windshieldTiltDegrees is the assumed windshield rake measured from
+	// vertical; its normal faces straight ahead, tilted up by this much.
+	windshieldTiltDegrees = 30.0
+	// defaultBlindingThreshold is the windshield-incident direct
+	// irradiance, W/m², above which the driver is considered blinded.
+	defaultBlindingThreshold = 600.0
+)
+
+// airmass returns the Kasten-Young relative optical airmass for a solar
+// zenith angle given in degrees.
+func airmass(zenithDegrees float64) float64 {
+	return 1 / (math.Cos(degreesToRadians(zenithDegrees)) + 0.50572*math.Pow(96.07995-zenithDegrees, -1.6364))
+}
+
+// pointElevationKm returns a track point's elevation in km, or 0 if the GPX
+// point carries no elevation.
+func pointElevationKm(point gpx.GPXPoint) float64 {
+	if point.Elevation.NotNull() {
+		return point.Elevation.Value() / 1000
+	}
+	return 0
+}
+
+// windshieldExposure estimates the direct-normal irradiance (dni) for a sun
+// at sunElevationDegrees over a point elevationKm above sea level, the
+// airmass (am) used to get there, and how much of it lands on a windshield
+// tilted windshieldTiltDegrees back from vertical and facing carHeading:
+// windshieldDirect is the direct-beam component alone, windshieldTotal adds
+// the constant diffuse-sky contribution. All irradiances are W/m².
+func windshieldExposure(sunAzimuthDegrees, sunElevationDegrees, carHeadingDegrees, elevationKm float64) (dni, am, windshieldDirect, windshieldTotal float64) {
+	if sunElevationDegrees <= 0 {
+		return 0, 0, 0, 0
+	}
+
+	zenithDegrees := 90 - sunElevationDegrees
+	am = airmass(zenithDegrees)
+	dni = solarConstant * math.Exp(-0.14*elevationKm) * math.Pow(0.7, math.Pow(am, 0.678))
+
+	sunZenith := degreesToRadians(zenithDegrees)
+	sunAz := degreesToRadians(sunAzimuthDegrees)
+	windshieldZenith := degreesToRadians(90 - windshieldTiltDegrees)
+	windshieldAz := degreesToRadians(carHeadingDegrees)
+
+	cosIncidence := math.Cos(sunZenith)*math.Cos(windshieldZenith) +
+		math.Sin(sunZenith)*math.Sin(windshieldZenith)*math.Cos(sunAz-windshieldAz)
+
+	windshieldDirect = dni * math.Max(0, cosIncidence)
+	windshieldTotal = windshieldDirect + dni*diffuseFraction
+	return dni, am, windshieldDirect, windshieldTotal
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAirmass(t *testing.T) {
+	cases := []struct {
+		name          string
+		zenithDegrees float64
+		want          float64
+	}{
+		{"overhead sun", 0, 1.0},
+		{"60 degrees zenith", 60, 2.0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := airmass(c.zenithDegrees); math.Abs(got-c.want) > 0.05 {
+				t.Errorf("airmass(%v) = %v, want ~%v", c.zenithDegrees, got, c.want)
+			}
+		})
+	}
+}
+
+func TestWindshieldExposureSunAtHorizon(t *testing.T) {
+	dni, am, direct, total := windshieldExposure(180, 0, 0, 0)
+	if dni != 0 || am != 0 || direct != 0 || total != 0 {
+		t.Errorf("windshieldExposure() with sun at the horizon = (%v, %v, %v, %v), want all zero", dni, am, direct, total)
+	}
+}
+
+func TestWindshieldExposureFacingSun(t *testing.T) {
+	// sun due south at 60° elevation, car heading south: the windshield
+	// normal (tilted windshieldTiltDegrees back from vertical) points
+	// straight at the sun's azimuth, so direct should be a large share of dni
+	_, _, direct, total := windshieldExposure(180, 60, 180, 0)
+	if direct <= 0 {
+		t.Fatalf("windshieldExposure() direct = %v, want > 0 for a sun-facing windshield", direct)
+	}
+	if total <= direct {
+		t.Errorf("windshieldExposure() total = %v, want > direct (%v) from the diffuse component", total, direct)
+	}
+}
+
+func TestWindshieldExposureSunBehindCar(t *testing.T) {
+	// sun due south, car heading north: the windshield faces away from the
+	// sun, so no direct-beam component should land on it
+	_, _, direct, _ := windshieldExposure(180, 60, 0, 0)
+	if direct > 1e-6 {
+		t.Errorf("windshieldExposure() direct = %v, want ~0 with the sun behind the car", direct)
+	}
+}
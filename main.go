@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/csv"
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"math"
 	"os"
@@ -18,7 +19,9 @@ import (
 
 func usage() {
 	fmt.Println("Add heading into a GPX file based on location between track points.")
-	fmt.Println("Usage: go run . <example.gpx> [pause detection as duratio e.g. 10s (default)]")
+	fmt.Println("Usage: go run . [--pause=10s] [--blinding-threshold=600] [--input=gpx|nmea] [--dem=<tiles-dir-or-url>] <example.gpx|example.nmea>")
+	fmt.Println("       go run . optimize-departure [--from=06:00] [--to=10:00] [--step=5m] [--route-reverse] <example.gpx>")
+	flag.PrintDefaults()
 	os.Exit(0)
 }
 
@@ -57,6 +60,10 @@ const (
 
 var currentSunState SunState
 
+// Garmin symbol used for the hazard waypoints dropped at the start of every
+// SunBlinding interval, so OsmAnd and friends render a recognizable pin.
+const hazardSymbol = "Danger Area"
+
 func (sunState SunState) EnumIndex() int {
 	return int(sunState)
 }
@@ -96,13 +103,16 @@ func (sunState SunState) getColorExtension() *gpx.ExtensionNode {
 	return extensionNode
 }
 
-func nextTrack(trackIndex int, gpxFile *gpx.GPX, previousPoint *gpx.GPXPoint) *gpx.GPXTrack {
+// nextTrack starts a new colored track carrying on from previousPoint (nil
+// when there is nothing to carry on from yet). number feeds gpxTrack.Number,
+// baseName identifies the source track/route this segment belongs to.
+func nextTrack(number int, baseName string, previousPoint *gpx.GPXPoint) *gpx.GPXTrack {
 	gpxTrack := &gpx.GPXTrack{
-		Name: gpxFile.Tracks[trackIndex].Name + " " + strconv.Itoa(trackIndex) + " " + currentSunState.ToString(),
+		Name: baseName + " " + currentSunState.ToString(),
 	}
 
 	gpxTrack.Extensions.Nodes = append(gpxTrack.Extensions.Nodes, *currentSunState.getColorExtension())
-	gpxTrack.Number.SetValue(trackIndex)
+	gpxTrack.Number.SetValue(number)
 	if previousPoint != nil {
 		gpxTrack.AppendSegment(nextSegment(previousPoint))
 	}
@@ -115,25 +125,375 @@ func nextSegment(previousPoint *gpx.GPXPoint) *gpx.GPXTrackSegment {
 	return gpxSegment
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		usage()
+// nextHazardWaypoint builds a standalone marker for the exact point a
+// SunBlinding interval begins, so consuming apps can pin the hazard instead
+// of only showing a colored track segment.
+func nextHazardWaypoint(point *gpx.GPXPoint, sunAzimuth, sunElevation, sunImpactAngle float64) *gpx.GPXPoint {
+	hazard := *point
+	hazard.Name = "Sun Blinding"
+	hazard.Symbol = hazardSymbol
+	hazard.Type = "hazard"
+	hazard.Description = fmt.Sprintf("Blinding sun at %s: azimuth %.1f°, elevation %.1f°, impact angle %.1f°",
+		point.Timestamp.Format(time.RFC3339), sunAzimuth, sunElevation, sunImpactAngle)
+	return &hazard
+}
+
+// evaluateWaypoint classifies the sun at a single named waypoint (start/end
+// context, or a parked stop) and folds the result into its description so
+// it carries through to the output GPX without needing the CSV files.
+func evaluateWaypoint(waypoint *gpx.GPXPoint) *gpx.GPXPoint {
+	out := *waypoint
+	sunAzimuth, sunElevation := calculateSunPosition(waypoint.Latitude, waypoint.Longitude, waypoint.Timestamp)
+	if waypoint.Latitude > 0 {
+		sunAzimuth += 180
+	}
+	sunAzimuth = math.Mod(sunAzimuth, 360)
+	if sunAzimuth < 0 {
+		sunAzimuth = 360 + sunAzimuth
+	}
+
+	state := SunState(SunUp)
+	if sunElevation < 0 {
+		state = SunDowned
+	} else if sunElevation < 15 {
+		state = SunLow
+	}
+
+	fmt.Println("Waypoint " + waypoint.Name + ": " + state.ToString() + ", sun azimuth " + strconv.FormatFloat(sunAzimuth, 'f', 1, 64) + ", elevation " + strconv.FormatFloat(sunElevation, 'f', 1, 64))
+
+	if out.Description != "" {
+		out.Description += " / "
+	}
+	out.Description += fmt.Sprintf("%s, sun azimuth %.1f°, elevation %.1f°", state.ToString(), sunAzimuth, sunElevation)
+	return &out
+}
+
+// pointMetrics is the per-leg heading/sun/windshield computation shared by
+// the main output pipeline and the departure-time optimizer.
+type pointMetrics struct {
+	carHeading       float64
+	sunAzimuth       float64
+	sunElevation     float64
+	sunImpactAngle   float64
+	dni              float64
+	airmass          float64
+	windshieldDirect float64
+	windshieldTotal  float64
+	durationSeconds  float64
+	// horizonAngle and terrainShaded are only populated when a DEM was
+	// supplied via --dem; terrainEvaluated distinguishes "no DEM" from a
+	// genuine 0° horizon.
+	horizonAngle     float64
+	terrainShaded    bool
+	terrainEvaluated bool
+}
+
+// computePointMetrics derives heading, sun position and windshield exposure
+// for the leg between prev and curr. ok is false when the leg should be
+// skipped: too long a gap (a parked pause) or no longitude movement to bear
+// a heading from. headingOverride, where non-nil, replaces the two-point
+// great-circle bearing (e.g. an authoritative NMEA VTG true course). dem,
+// where non-nil, is sampled along the sun azimuth to tell real terrain
+// shading from a merely geometrically-visible sun.
+func computePointMetrics(prev, curr gpx.GPXPoint, headingOverride *float64, pauseDetectDuration time.Duration, dem DEMSource) (metrics pointMetrics, ok bool) {
+	timegap := curr.Timestamp.Sub(prev.Timestamp)
+	// on gap being larger than threshold, ignore this value (pause detection)
+	if timegap > pauseDetectDuration {
+		return pointMetrics{}, false
+	}
+
+	// inputs for calculating the angle between two subsequent points (car direction)
+	phi1 := degreesToRadians(prev.Latitude)
+	lambda1 := degreesToRadians(prev.Longitude)
+	phi2 := degreesToRadians(curr.Latitude)
+	lambda2 := degreesToRadians(curr.Longitude)
+
+	deltaLambda := lambda2 - lambda1
+	// skip two dots on same location: no movement, not computable
+	// TODO: check for rare case of move along Latitude only, what is done then? Some GPX loggers are poor on resolution....
+	if deltaLambda == 0 {
+		return pointMetrics{}, false
+	}
+
+	leftSide := math.Sin(deltaLambda) * math.Cos(phi2)
+	rightSide := (math.Cos(phi1) * math.Sin(phi2)) - (math.Sin(phi1) * math.Cos(phi2) * math.Cos(deltaLambda))
+	theta := math.Atan2(leftSide, rightSide)
+	// car direction
+	carHeading := theta * 180 / math.Pi
+	// normalize to 360°
+	carHeading = math.Mod(carHeading, 360)
+	if carHeading < 0 {
+		carHeading = 360 + carHeading
+	}
+	if headingOverride != nil {
+		carHeading = *headingOverride
+	}
+
+	sunAzimuth, sunElevation := calculateSunPosition(curr.Latitude, curr.Longitude, curr.Timestamp)
+	// suncalc reports azimuth measured from south; this is the true
+	// geographic (north-referenced) compass bearing to the sun, unlike
+	// sunAzimuth below which only gets that conversion in the northern
+	// hemisphere to match this pipeline's existing impact-angle math
+	geographicSunAzimuth := math.Mod(sunAzimuth+180, 360)
+	if geographicSunAzimuth < 0 {
+		geographicSunAzimuth = 360 + geographicSunAzimuth
 	}
+	// orientation fix for hemisphere
+	if curr.Latitude > 0 {
+		sunAzimuth += 180
+	}
+	// normalize to 360°
+	sunAzimuth = math.Mod(sunAzimuth, 360)
+	if sunAzimuth < 0 {
+		sunAzimuth = 360 + sunAzimuth
+	}
+	// calc sun impact relative to direction of car
+	sunImpactAngle := math.Mod(sunAzimuth-carHeading, 360)
+	// normalize to 360°
+	if sunImpactAngle < 0 {
+		sunImpactAngle = 360 + sunImpactAngle
+	}
+
+	dni, am, windshieldDirect, windshieldTotal := windshieldExposure(sunAzimuth, sunElevation, carHeading, pointElevationKm(curr))
+
+	metrics = pointMetrics{
+		carHeading:       carHeading,
+		sunAzimuth:       sunAzimuth,
+		sunElevation:     sunElevation,
+		sunImpactAngle:   sunImpactAngle,
+		dni:              dni,
+		airmass:          am,
+		windshieldDirect: windshieldDirect,
+		windshieldTotal:  windshieldTotal,
+		durationSeconds:  timegap.Seconds(),
+	}
+
+	if dem != nil {
+		pointElevationM := 0.0
+		if curr.Elevation.NotNull() {
+			pointElevationM = curr.Elevation.Value()
+		}
+		if horizonAngle, err := horizonAngleDegrees(dem, curr.Latitude, curr.Longitude, pointElevationM, geographicSunAzimuth); err == nil {
+			metrics.horizonAngle = horizonAngle
+			metrics.terrainEvaluated = true
+			metrics.terrainShaded = sunElevation < horizonAngle
+		}
+	}
+
+	return metrics, true
+}
+
+// csvRow formats one per-point detail row, shared by the normal and
+// terrain-shaded branches of processPoints so the column set stays in sync.
+func csvRow(point, previousPoint gpx.GPXPoint, metrics pointMetrics, terrainShaded bool) []string {
+	return []string{
+		point.Timestamp.String(),
+		point.Timestamp.Sub(previousPoint.Timestamp).String(),
+		strconv.FormatFloat(point.Latitude, 'f', 6, 64),
+		strconv.FormatFloat(point.Longitude, 'f', 6, 64),
+		strconv.FormatFloat(metrics.carHeading, 'f', 6, 64),
+		strconv.FormatFloat(metrics.sunAzimuth, 'f', 6, 64),
+		strconv.FormatFloat(metrics.sunElevation, 'f', 6, 64),
+		strconv.FormatFloat(metrics.sunImpactAngle, 'f', 6, 64),
+		strconv.FormatFloat(metrics.dni, 'f', 2, 64),
+		strconv.FormatFloat(metrics.windshieldTotal, 'f', 2, 64),
+		strconv.FormatFloat(metrics.airmass, 'f', 3, 64),
+		strconv.FormatBool(terrainShaded),
+	}
+}
+
+// processPoints runs the heading/sun-impact pipeline over one linear run of
+// points - a track segment's trkpt list or a whole route's rtept list - and
+// writes its per-point and aggregated CSV files. trackNumber/baseName feed
+// nextTrack so the colored output tracks carry the right label, csvLabel
+// distinguishes this run's CSV files from any others sharing baseFilename.
+func processPoints(points []gpx.GPXPoint, headingOverrides map[int]float64, trackNumber int, baseName string, csvLabel string, gpxOutput *gpx.GPX, pauseDetectDuration time.Duration, blindingThreshold float64, baseFilename string, dem DEMSource) {
+	// initialize data buckets
+	// counts of instances, useful on even timespans only, offers direct normalization for easier comparism
+	sunImpactDistribution := make([]float64, 360)
+	// time of sun exposure per degree of impact to a car
+	sunImpactDistributionTime := make([]float64, 360)
+	// time of deep standing sun exposure per degree of impact to a car
+	deepSunImpactDistributionTime := make([]float64, 360)
+	// time of blinding sun exposure per degree of impact to a car
+	blindingSunImpactDistributionTime := make([]float64, 360)
+	// integrated windshield irradiance (J/m²) per degree of impact to a car
+	windshieldExposureDistribution := make([]float64, 360)
+
+	// create csv files for this run of points
+	csvHeadings, err := os.Create(baseFilename + "_" + csvLabel + ".csv")
+	check(err)
+	csvHeadingsWriter := csv.NewWriter(csvHeadings)
+	csvHeadingsWriter.Write([]string{"timestamp", "timegap", "lat", "lon", "carHeading", "sunAzimuth", "sunElevation", "sunImpactAngle", "dni", "windshield_irradiance", "airmass", "terrainShaded"})
+
+	for pointIndex := range points {
+		if pointIndex == 0 {
+			continue
+		}
+
+		var headingOverride *float64
+		if override, ok := headingOverrides[pointIndex]; ok {
+			headingOverride = &override
+		}
+		metrics, ok := computePointMetrics(points[pointIndex-1], points[pointIndex], headingOverride, pauseDetectDuration, dem)
+		if !ok {
+			continue
+		}
+
+		// a sun that is set, it makes it to the graph but not into the statistics
+		if metrics.sunElevation < 0 {
+			if currentSunState.hasChanged(SunDowned) {
+				gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, &points[pointIndex-1]))
+			}
+			attachSunExtensions(&points[pointIndex], metrics.sunAzimuth, metrics.sunElevation, metrics.sunImpactAngle, metrics.carHeading, currentSunState)
+			if metrics.terrainEvaluated {
+				attachTerrainExtension(&points[pointIndex], metrics.horizonAngle)
+			}
+			gpxOutput.AppendPoint(&points[pointIndex])
+			continue
+		}
+
+		// terrain hides a sun that is geometrically above the horizon:
+		// classify and color it like SunDowned, but keep the full CSV row
+		// with the raw (pre-shading) sun angles so the shading is visible
+		if metrics.terrainShaded {
+			if currentSunState.hasChanged(SunDowned) {
+				gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, &points[pointIndex-1]))
+			}
+			attachSunExtensions(&points[pointIndex], metrics.sunAzimuth, metrics.sunElevation, metrics.sunImpactAngle, metrics.carHeading, currentSunState)
+			attachTerrainExtension(&points[pointIndex], metrics.horizonAngle)
+			gpxOutput.AppendPoint(&points[pointIndex])
+			csvHeadingsWriter.Write(csvRow(points[pointIndex], points[pointIndex-1], metrics, true))
+			continue
+		}
 
-	pauseDetectDuration, _ := time.ParseDuration("10s")
-	if len(os.Args) > 2 {
-		pauseDetectDuration, _ = time.ParseDuration(os.Args[2])
+		// collect a value into a stack per degree of sun impact to car direction
+		sunImpactDistribution[int(metrics.sunImpactAngle)]++
+		sunImpactDistributionTime[int(metrics.sunImpactAngle)] += metrics.durationSeconds
+		windshieldExposureDistribution[int(metrics.sunImpactAngle)] += metrics.windshieldTotal * metrics.durationSeconds
+
+		// deep sun is sun impact below 15° elevation, tracked as an auxiliary metric
+		if metrics.sunElevation < 15 {
+			deepSunImpactDistributionTime[int(metrics.sunImpactAngle)] += metrics.durationSeconds
+		}
+
+		// blinding sun is classified by direct windshield irradiance
+		// crossing the configured threshold, not by geometry alone
+		if metrics.windshieldDirect > blindingThreshold {
+			blindingSunImpactDistributionTime[int(metrics.sunImpactAngle)] += metrics.durationSeconds
+			if currentSunState.hasChanged(SunBlinding) {
+				gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, &points[pointIndex-1]))
+				gpxOutput.Waypoints = append(gpxOutput.Waypoints, *nextHazardWaypoint(&points[pointIndex], metrics.sunAzimuth, metrics.sunElevation, metrics.sunImpactAngle))
+			}
+		} else if metrics.sunElevation < 15 {
+			if currentSunState.hasChanged(SunLow) {
+				gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, &points[pointIndex-1]))
+			}
+		} else {
+			if currentSunState.hasChanged(SunUp) {
+				gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, &points[pointIndex-1]))
+			}
+		}
+		attachSunExtensions(&points[pointIndex], metrics.sunAzimuth, metrics.sunElevation, metrics.sunImpactAngle, metrics.carHeading, currentSunState)
+		if metrics.terrainEvaluated {
+			attachTerrainExtension(&points[pointIndex], metrics.horizonAngle)
+		}
+		gpxOutput.AppendPoint(&points[pointIndex])
+
+		// write raw stuff
+		csvHeadingsWriter.Write(csvRow(points[pointIndex], points[pointIndex-1], metrics, false))
 	}
-	fmt.Println("running with " + pauseDetectDuration.String() + " pause detection")
+	csvHeadingsWriter.Flush()
+	csvHeadings.Close()
 
-	// GPX input file
-	filename := os.Args[1]
-	payload, err := os.ReadFile(filename)
+	// compute quartiles
+	quartiles, err := stats.Quartile(sunImpactDistributionTime)
+	check(err)
+	interQuartileRange, err := stats.InterQuartileRange(sunImpactDistributionTime)
+	check(err)
+	maxSunImpactTime, err := stats.Max(sunImpactDistributionTime)
+	check(err)
+	sumSunBlinding, err := stats.Sum(blindingSunImpactDistributionTime)
 	check(err)
+	sumWindshieldExposure, err := stats.Sum(windshieldExposureDistribution)
+	check(err)
+
+	fmt.Println(baseName + " Timed InterQuartileRange: " + strconv.FormatFloat(interQuartileRange, 'f', 0, 64) + ", Peak factor: " + strconv.FormatFloat(maxSunImpactTime/interQuartileRange, 'f', 2, 64) + ", blinding for " + strconv.FormatFloat(sumSunBlinding/60, 'f', 2, 64) + " minutes, windshield exposure " + strconv.FormatFloat(sumWindshieldExposure/1000, 'f', 0, 64) + " kJ/m².")
 
-	// parse input from GPX format
-	gpxFile, err := gpx.ParseBytes(payload)
+	// write collected data stuff
+	csvSunImpact, err := os.Create(baseFilename + "_" + csvLabel + ".sunimpact.csv")
 	check(err)
+	csvSunImpactWriter := csv.NewWriter(csvSunImpact)
+	csvSunImpactWriter.Write([]string{"Impact Angle", "count", "normalized count", "timesum sun", "timesum deep sun", "windshield exposure Jm2", "Q1 timed", "Q2 timed", "Q3 timed"})
+
+	// max, to normalize to 100 slices.Max()
+	maxSunImpactDistribution := slices.Max(sunImpactDistribution)
+	for carAngleIndex := range sunImpactDistributionTime {
+		csvSunImpactWriter.Write([]string{
+			strconv.Itoa(carAngleIndex),
+			strconv.FormatFloat(sunImpactDistribution[carAngleIndex], 'f', 2, 64),
+			strconv.FormatFloat(sunImpactDistribution[carAngleIndex]*100/maxSunImpactDistribution, 'f', 2, 64),
+			strconv.FormatFloat(sunImpactDistributionTime[carAngleIndex], 'f', 2, 64),
+			strconv.FormatFloat(deepSunImpactDistributionTime[carAngleIndex], 'f', 2, 64),
+			strconv.FormatFloat(windshieldExposureDistribution[carAngleIndex], 'f', 2, 64),
+			strconv.FormatFloat(quartiles.Q1, 'f', 2, 64),
+			strconv.FormatFloat(quartiles.Q2, 'f', 2, 64),
+			strconv.FormatFloat(quartiles.Q3, 'f', 2, 64)})
+	}
+	csvSunImpactWriter.Flush()
+	csvSunImpact.Close()
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "optimize-departure" {
+		optimizeDeparture(os.Args[2:])
+		return
+	}
+
+	pauseFlag := flag.Duration("pause", 10*time.Second, "pause detection duration")
+	blindingFlag := flag.Float64("blinding-threshold", defaultBlindingThreshold, "windshield irradiance threshold for SunBlinding, W/m^2")
+	inputFormatFlag := flag.String("input", "", "input format: gpx or nmea; auto-detected from file extension (.nmea/.log) when omitted")
+	gpxVersionFlag := flag.String("gpx-version", "1.1", "output GPX version: 1.1 (per-point <extensions>) or 1.0 (folded into <cmt>)")
+	demFlag := flag.String("dem", "", "terrain-shading DEM: a directory of SRTM .hgt tiles or an http(s) tile service; omit to skip terrain shading")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+	}
+	pauseDetectDuration := *pauseFlag
+	blindingThreshold := *blindingFlag
+	fmt.Println("running with " + pauseDetectDuration.String() + " pause detection, blinding threshold " + strconv.FormatFloat(blindingThreshold, 'f', 0, 64) + " W/m²")
+
+	var dem DEMSource
+	if *demFlag != "" {
+		var err error
+		dem, err = newDEMSource(*demFlag)
+		check(err)
+	}
+
+	filename := flag.Arg(0)
+	inputFormat := *inputFormatFlag
+	if inputFormat == "" {
+		inputFormat = detectInputFormat(filename)
+	}
+
+	// align filename with name from input file
+	baseFilename := filename[0 : len(filename)-len(filepath.Ext(filename))]
+
+	var gpxFile *gpx.GPX
+	var nmeaHeadingOverrides map[int]float64
+	switch inputFormat {
+	case "nmea":
+		var err error
+		gpxFile, nmeaHeadingOverrides, err = parseNMEAFile(filename)
+		check(err)
+	default:
+		payload, err := os.ReadFile(filename)
+		check(err)
+		gpxFile, err = gpx.ParseBytes(payload)
+		check(err)
+	}
 
 	// GPX output file
 	gpxOutput := &gpx.GPX{
@@ -146,172 +506,60 @@ func main() {
 		CopyrightLicense: gpxFile.CopyrightLicense,
 	}
 	gpxOutput.RegisterNamespace("gpxx", "http://www.garmin.com/xmlschemas/GpxExtensions/v3")
+	gpxVersion := *gpxVersionFlag
+	// the nmea/shr extension nodes are folded into <cmt> and cleared for
+	// GPX 1.0 output, so only register their namespaces on the 1.1 path
+	if gpxVersion == "1.1" {
+		if inputFormat == "nmea" {
+			gpxOutput.RegisterNamespace("nmea", nmeaNamespace)
+		}
+		gpxOutput.RegisterNamespace("shr", shrNamespace)
+	}
 	currentSunState.hasChanged(Unknown)
 
 	// for each track, segments inside track, all points inside each of the segments
 	for trackIndex := range gpxFile.Tracks {
-		gpxOutput.AppendTrack(nextTrack(trackIndex, gpxFile, nil))
+		baseName := gpxFile.Tracks[trackIndex].Name + " " + strconv.Itoa(trackIndex)
+		gpxOutput.AppendTrack(nextTrack(trackIndex, baseName, nil))
 
 		for segIndex := range gpxFile.Tracks[trackIndex].Segments {
-			// initialize data buckets
-			// counts of instances, useful on even timespans only, offers direct normalization for easier comparism
-			sunImpactDistribution := make([]float64, 360)
-			// time of sun exposure per degree of impact to a car
-			sunImpactDistributionTime := make([]float64, 360)
-			// time of deep standing sun exposure per degree of impact to a car
-			deepSunImpactDistributionTime := make([]float64, 360)
-			// time of blinding sun exposure per degree of impact to a car
-			blindingSunImpactDistributionTime := make([]float64, 360)
-
-			// align filename with name from input file
-			filename = filename[0 : len(filename)-len(filepath.Ext(filename))]
-
-			// create csv files for each GPX segment
-			csvHeadings, err := os.Create(filename + "_" + strconv.Itoa(trackIndex) + "_" + strconv.Itoa(segIndex) + ".csv")
-			check(err)
-			csvHeadingsWriter := csv.NewWriter(csvHeadings)
-			csvHeadingsWriter.Write([]string{"timestamp", "timegap", "lat", "lon", "carHeading", "sunAzimuth", "sunElevation", "sunImpactAngle"})
-
-			for pointIndex := range gpxFile.Tracks[trackIndex].Segments[segIndex].Points {
-				if pointIndex > 0 {
-					// check time gap between two subsequent track points
-					timegap := gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Timestamp.Sub(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1].Timestamp)
-					// on gap being larger than threshold, ignore this value (pause detection)
-					if timegap > pauseDetectDuration {
-						continue
-					}
-					// inputs for calculating the angle between two subsequent track points (car direction)
-					phi1 := degreesToRadians(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1].Latitude)
-					lambda1 := degreesToRadians(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1].Longitude)
-					phi2 := degreesToRadians(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Latitude)
-					lambda2 := degreesToRadians(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Longitude)
-
-					deltaLambda := lambda2 - lambda1
-					// skip two dots on same location: no movement, not computable
-					// TODO: check for rare case of move along Latitude only, what is done then? Some GPX loggers are poor on resolution....
-					if deltaLambda == 0 {
-						continue
-					}
-
-					leftSide := math.Sin(deltaLambda) * math.Cos(phi2)
-					rightSide := (math.Cos(phi1) * math.Sin(phi2)) - (math.Sin(phi1) * math.Cos(phi2) * math.Cos(deltaLambda))
-					theta := math.Atan2(leftSide, rightSide)
-					// car direction
-					carHeading := theta * 180 / math.Pi
-
-					// normalize to 360°
-					carHeading = math.Mod(carHeading, 360)
-					if carHeading < 0 {
-						carHeading = 360 + carHeading
-					}
-
-					sunAzimuth, sunElevation := calculateSunPosition(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Latitude, gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Longitude, gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Timestamp)
-					// a sun that is set, it makes it to the graph but not into the statistics
-					if sunElevation < 0 {
-						if currentSunState.hasChanged(SunDowned) {
-							gpxOutput.AppendTrack(nextTrack(trackIndex, gpxFile, &gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1]))
-						}
-						gpxOutput.AppendPoint(&gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex])
-						continue
-					}
-					// orientation fix for hemisphere
-					if gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Latitude > 0 {
-						sunAzimuth += 180
-					}
-					// normalize to 360°
-					sunAzimuth = math.Mod(sunAzimuth, 360)
-					if sunAzimuth < 0 {
-						sunAzimuth = 360 + sunAzimuth
-					}
-					// calc sun impact relative to direction of car
-					sunImpactAngle := math.Mod(sunAzimuth-carHeading, 360)
-					// normalize to 360°
-					if sunImpactAngle < 0 {
-						sunImpactAngle = 360 + sunImpactAngle
-					}
-
-					// collect a value into a stack per degree of sun impact to car direction
-					sunImpactDistribution[int(sunImpactAngle)]++
-
-					sunImpactDurationSeconds := gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Timestamp.Sub(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1].Timestamp).Seconds()
-					sunImpactDistributionTime[int(sunImpactAngle)] += sunImpactDurationSeconds
-
-					// deep sun is sun impact below 15° elevation
-					if (sunElevation > 0) && (sunElevation < 15) {
-						deepSunImpactDistributionTime[int(sunImpactAngle)] += sunImpactDurationSeconds
-						// blinding sun is deep sun and +-30° heading north
-						if (sunImpactAngle < 30) || (sunImpactAngle > 330) {
-							blindingSunImpactDistributionTime[int(sunImpactAngle)] += sunImpactDurationSeconds
-							if currentSunState.hasChanged(SunBlinding) {
-								gpxOutput.AppendTrack(nextTrack(trackIndex, gpxFile, &gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1]))
-							}
-						} else {
-							if currentSunState.hasChanged(SunLow) {
-								gpxOutput.AppendTrack(nextTrack(trackIndex, gpxFile, &gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1]))
-							}
-						}
-					} else {
-						if currentSunState.hasChanged(SunUp) {
-							gpxOutput.AppendTrack(nextTrack(trackIndex, gpxFile, &gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex-1]))
-						}
-					}
-					gpxOutput.AppendPoint(&gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex])
-
-					// write raw stuff
-					csvHeadingsWriter.Write([]string{
-						gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Timestamp.String(),
-						timegap.String(),
-						strconv.FormatFloat(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Latitude, 'f', 6, 64),
-						strconv.FormatFloat(gpxFile.Tracks[trackIndex].Segments[segIndex].Points[pointIndex].Longitude, 'f', 6, 64),
-						strconv.FormatFloat(carHeading, 'f', 6, 64),
-						strconv.FormatFloat(sunAzimuth, 'f', 6, 64),
-						strconv.FormatFloat(sunElevation, 'f', 6, 64),
-						strconv.FormatFloat(sunImpactAngle, 'f', 6, 64)})
-				}
-			}
-			csvHeadingsWriter.Flush()
-			csvHeadings.Close()
-
-			// compute quartiles
-			quartiles, err := stats.Quartile(sunImpactDistributionTime)
-			check(err)
-			interQuartileRange, err := stats.InterQuartileRange(sunImpactDistributionTime)
-			check(err)
-			maxSunImpactTime, err := stats.Max(sunImpactDistributionTime)
-			check(err)
-			sumSunBlinding, err := stats.Sum(blindingSunImpactDistributionTime)
-			check(err)
-
-			fmt.Println("Track: " + strconv.Itoa(trackIndex) + " Segment: " + strconv.Itoa(segIndex) + " Timed InterQuartileRange: " + strconv.FormatFloat(interQuartileRange, 'f', 0, 64) + ", Peak factor: " + strconv.FormatFloat(maxSunImpactTime/interQuartileRange, 'f', 2, 64) + ", blinding for " + strconv.FormatFloat(sumSunBlinding/60, 'f', 2, 64) + " minutes.")
-
-			// write collected data stuff
-			csvSunImpact, err := os.Create(filename + "_" + strconv.Itoa(trackIndex) + "_" + strconv.Itoa(segIndex) + ".sunimpact.csv")
-			check(err)
-			csvSunImpactWriter := csv.NewWriter(csvSunImpact)
-			csvSunImpactWriter.Write([]string{"Impact Angle", "count", "normalized count", "timesum sun", "timesum deep sun", "Q1 timed", "Q2 timed", "Q3 timed"})
-
-			// max, to normalize to 100 slices.Max()
-			maxSunImpactDistribution := slices.Max(sunImpactDistribution)
-			for carAngleIndex := range sunImpactDistributionTime {
-				csvSunImpactWriter.Write([]string{
-					strconv.Itoa(carAngleIndex),
-					strconv.FormatFloat(sunImpactDistribution[carAngleIndex], 'f', 2, 64),
-					strconv.FormatFloat(sunImpactDistribution[carAngleIndex]*100/maxSunImpactDistribution, 'f', 2, 64),
-					strconv.FormatFloat(sunImpactDistributionTime[carAngleIndex], 'f', 2, 64),
-					strconv.FormatFloat(deepSunImpactDistributionTime[carAngleIndex], 'f', 2, 64),
-					strconv.FormatFloat(quartiles.Q1, 'f', 2, 64),
-					strconv.FormatFloat(quartiles.Q2, 'f', 2, 64),
-					strconv.FormatFloat(quartiles.Q3, 'f', 2, 64)})
+			csvLabel := strconv.Itoa(trackIndex) + "_" + strconv.Itoa(segIndex)
+			var headingOverrides map[int]float64
+			if trackIndex == 0 && segIndex == 0 {
+				headingOverrides = nmeaHeadingOverrides
 			}
-			csvSunImpactWriter.Flush()
-			csvSunImpact.Close()
+			processPoints(gpxFile.Tracks[trackIndex].Segments[segIndex].Points, headingOverrides, trackIndex, baseName, csvLabel, gpxOutput, pauseDetectDuration, blindingThreshold, baseFilename, dem)
 		}
 	}
+
+	// routes carry a single ordered rtept sequence, so each route is
+	// processed like a track made of exactly one segment
+	for routeIndex := range gpxFile.Routes {
+		baseName := gpxFile.Routes[routeIndex].Name + " route " + strconv.Itoa(routeIndex)
+		trackNumber := len(gpxFile.Tracks) + routeIndex
+		gpxOutput.AppendTrack(nextTrack(trackNumber, baseName, nil))
+
+		csvLabel := "route_" + strconv.Itoa(routeIndex)
+		processPoints(gpxFile.Routes[routeIndex].Points, nil, trackNumber, baseName, csvLabel, gpxOutput, pauseDetectDuration, blindingThreshold, baseFilename, dem)
+	}
+
+	// waypoints give start/end context (or per-stop evaluation for parked
+	// legs); evaluate the sun at each one and carry it through to the output
+	for waypointIndex := range gpxFile.Waypoints {
+		gpxOutput.Waypoints = append(gpxOutput.Waypoints, *evaluateWaypoint(&gpxFile.Waypoints[waypointIndex]))
+	}
+
+	// GPX 1.0 has no trkpt/rtept/wpt <extensions>, so fold the sun geometry
+	// into <cmt> instead of silently losing it
+	if gpxVersion == "1.0" {
+		encodeExtensionsAsComments(gpxOutput)
+	}
+
 	// create output GPX file
-	xmlBytes, err := gpxOutput.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+	xmlBytes, err := gpxOutput.ToXml(gpx.ToXmlParams{Version: gpxVersion, Indent: true})
 	check(err)
 	// write GPX XML output
-	err = os.WriteFile(filename+".sunstatus.gpx", xmlBytes, 0666)
+	err = os.WriteFile(baseFilename+".sunstatus.gpx", xmlBytes, 0666)
 	check(err)
 
 }
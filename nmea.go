@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+// nmeaNamespace tags the extension nodes this adapter attaches to points it
+// cannot express with native GPX fields (satellite count in view, raw speed).
+const nmeaNamespace = "https://everydayroadster.example/gpx-sunheadinger/nmea"
+
+// detectInputFormat guesses the input format from a file's extension so
+// dashcam/logger dumps (.nmea, .log) are read as NMEA 0183 instead of GPX.
+func detectInputFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".nmea", ".log":
+		return "nmea"
+	default:
+		return "gpx"
+	}
+}
+
+// parseNMEAFile turns a raw NMEA 0183 stream ($GPRMC/$GPGGA/$GPVTG/$GPGSV)
+// into a single-track, single-segment GPX file compatible with the rest of
+// the pipeline. It also returns a point-index -> true-course map for points
+// where a VTG sentence gave an authoritative heading, so the caller can
+// bypass the two-point great-circle bearing (noisy at low speed) for those
+// points.
+//
+// Sentences are assumed to arrive grouped per fix epoch, RMC first, as is
+// standard for NMEA loggers: GGA/VTG/GSV update whichever point the most
+// recent RMC produced.
+func parseNMEAFile(filename string) (*gpx.GPX, map[int]float64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var points []gpx.GPXPoint
+	headingOverrides := make(map[int]float64)
+	lastIndex := -1
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] != '$' || !nmeaChecksumValid(line) {
+			continue
+		}
+		star := strings.LastIndex(line, "*")
+		fields := strings.Split(line[1:star], ",")
+		if len(fields[0]) < 5 {
+			continue
+		}
+
+		// fields[0] is talker+sentence id, e.g. GPRMC/GNRMC/GPGGA; only the
+		// last three characters identify the sentence type
+		switch fields[0][len(fields[0])-3:] {
+		case "RMC":
+			if len(fields) < 10 || fields[2] != "A" {
+				continue // void fix, nothing usable this epoch
+			}
+			timestamp, err := parseNMEATimestamp(fields[9], fields[1])
+			if err != nil {
+				continue
+			}
+			latitude, err := parseNMEACoordinate(fields[3], 2, fields[4])
+			if err != nil {
+				continue
+			}
+			longitude, err := parseNMEACoordinate(fields[5], 3, fields[6])
+			if err != nil {
+				continue
+			}
+			point := gpx.GPXPoint{
+				Point:     gpx.Point{Latitude: latitude, Longitude: longitude},
+				Timestamp: timestamp,
+			}
+			if speedKnots, err := strconv.ParseFloat(fields[7], 64); err == nil {
+				appendNMEAExtension(&point, "GroundSpeed", strconv.FormatFloat(speedKnots*0.514444, 'f', 3, 64))
+			}
+			points = append(points, point)
+			lastIndex = len(points) - 1
+		case "GGA":
+			if lastIndex < 0 || len(fields) < 10 {
+				continue
+			}
+			if altitude, err := strconv.ParseFloat(fields[9], 64); err == nil {
+				points[lastIndex].Elevation.SetValue(altitude)
+			}
+			if satellites, err := strconv.Atoi(fields[7]); err == nil {
+				points[lastIndex].Satellites.SetValue(satellites)
+			}
+		case "VTG":
+			if lastIndex < 0 || len(fields) < 2 {
+				continue
+			}
+			if trueCourse, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				headingOverrides[lastIndex] = trueCourse
+			}
+		case "GSV":
+			if lastIndex < 0 || len(fields) < 4 {
+				continue
+			}
+			if satellitesInView, err := strconv.Atoi(fields[3]); err == nil {
+				appendNMEAExtension(&points[lastIndex], "SatellitesInView", strconv.Itoa(satellitesInView))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	gpxFile := &gpx.GPX{
+		Tracks: []gpx.GPXTrack{{
+			Name:     filepath.Base(filename),
+			Segments: []gpx.GPXTrackSegment{{Points: points}},
+		}},
+	}
+	return gpxFile, headingOverrides, nil
+}
+
+func appendNMEAExtension(point *gpx.GPXPoint, local, data string) {
+	point.Extensions.Nodes = append(point.Extensions.Nodes, gpx.ExtensionNode{
+		XMLName: xml.Name{Space: nmeaNamespace, Local: local},
+		Data:    data,
+	})
+}
+
+// nmeaChecksumValid verifies the *hh checksum NMEA sentences are trailed
+// with, XORing every byte between '$' and '*'.
+func nmeaChecksumValid(line string) bool {
+	star := strings.LastIndex(line, "*")
+	if star < 1 || star+3 > len(line) {
+		return false
+	}
+	want, err := strconv.ParseUint(line[star+1:star+3], 16, 8)
+	if err != nil {
+		return false
+	}
+	got := byte(0)
+	for i := 1; i < star; i++ {
+		got ^= line[i]
+	}
+	return got == byte(want)
+}
+
+// parseNMEACoordinate decodes a ddmm.mmmm (latitude, degreeDigits=2) or
+// dddmm.mmmm (longitude, degreeDigits=3) field into signed decimal degrees.
+func parseNMEACoordinate(raw string, degreeDigits int, hemisphere string) (float64, error) {
+	if len(raw) < degreeDigits+3 {
+		return 0, fmt.Errorf("nmea: malformed coordinate %q", raw)
+	}
+	degrees, err := strconv.ParseFloat(raw[:degreeDigits], 64)
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseFloat(raw[degreeDigits:], 64)
+	if err != nil {
+		return 0, err
+	}
+	value := degrees + minutes/60
+	if hemisphere == "S" || hemisphere == "W" {
+		value = -value
+	}
+	return value, nil
+}
+
+// parseNMEATimestamp combines RMC's ddmmyy date field and hhmmss.sss time
+// field into a UTC timestamp.
+func parseNMEATimestamp(dateField, timeField string) (time.Time, error) {
+	if len(dateField) < 6 || len(timeField) < 6 {
+		return time.Time{}, fmt.Errorf("nmea: malformed date/time %q %q", dateField, timeField)
+	}
+	day, err := strconv.Atoi(dateField[0:2])
+	if err != nil {
+		return time.Time{}, err
+	}
+	month, err := strconv.Atoi(dateField[2:4])
+	if err != nil {
+		return time.Time{}, err
+	}
+	year, err := strconv.Atoi(dateField[4:6])
+	if err != nil {
+		return time.Time{}, err
+	}
+	hour, err := strconv.Atoi(timeField[0:2])
+	if err != nil {
+		return time.Time{}, err
+	}
+	minute, err := strconv.Atoi(timeField[2:4])
+	if err != nil {
+		return time.Time{}, err
+	}
+	secondsFloat, err := strconv.ParseFloat(timeField[4:], 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds := int(secondsFloat)
+	nanoseconds := int((secondsFloat - float64(seconds)) * 1e9)
+
+	return time.Date(2000+year, time.Month(month), day, hour, minute, seconds, nanoseconds, time.UTC), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNmeaChecksumValid(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want bool
+	}{
+		{"valid RMC", "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A", true},
+		{"wrong checksum", "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*00", false},
+		{"no star", "$GPRMC,123519,A,4807.038,N", false},
+		{"star too close to end", "$GPRMC*6", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := nmeaChecksumValid(c.line); got != c.want {
+				t.Errorf("nmeaChecksumValid(%q) = %v, want %v", c.line, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseNMEACoordinate(t *testing.T) {
+	cases := []struct {
+		name         string
+		raw          string
+		degreeDigits int
+		hemisphere   string
+		want         float64
+		wantErr      bool
+	}{
+		{"latitude north", "4807.038", 2, "N", 48 + 7.038/60, false},
+		{"latitude south", "4807.038", 2, "S", -(48 + 7.038/60), false},
+		{"longitude east", "01131.000", 3, "E", 11 + 31.0/60, false},
+		{"longitude west", "01131.000", 3, "W", -(11 + 31.0/60), false},
+		{"too short", "123", 2, "N", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseNMEACoordinate(c.raw, c.degreeDigits, c.hemisphere)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("parseNMEACoordinate(%q) expected an error, got %v", c.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseNMEACoordinate(%q) unexpected error: %v", c.raw, err)
+			}
+			if math.Abs(got-c.want) > 1e-9 {
+				t.Errorf("parseNMEACoordinate(%q) = %v, want %v", c.raw, got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseNMEATimestamp(t *testing.T) {
+	got, err := parseNMEATimestamp("230394", "123519.00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := time.Date(2094, time.March, 23, 12, 35, 19, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("parseNMEATimestamp() = %v, want %v", got, want)
+	}
+
+	if _, err := parseNMEATimestamp("23", "12"); err == nil {
+		t.Error("parseNMEATimestamp() with malformed fields expected an error, got none")
+	}
+}
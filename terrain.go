@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Terrain shading: mountains and canyons hide a sun that is geometrically
+// above the horizon. We sample a DEM along the azimuth to the sun out to
+// horizonSampleMaxKm and find the steepest elevation angle blocking the
+// view, the horizon angle. A sun below it is effectively down.
+const (
+	earthRadiusKm       = 6371.0
+	horizonSampleStepKm = 1.0
+	horizonSampleMaxKm  = 30.0
+	// curvatureCoefficient is the standard Earth-curvature + refraction
+	// correction applied to the horizon-dip formula (R in km, d in km).
+	curvatureCoefficient = 0.13
+)
+
+// DEMSource looks up ground elevation (meters) for a lat/lon, from a local
+// tile set or a remote service.
+type DEMSource interface {
+	ElevationAt(lat, lon float64) (float64, error)
+}
+
+// newDEMSource builds a DEMSource from the --dem flag: an HTTP(S) URL for a
+// tile service, a directory of SRTM .hgt tiles, or a single .hgt file (its
+// containing directory is used, so neighbouring tiles still resolve if the
+// horizon sweep crosses into them). GeoTIFF isn't supported - there's no
+// TIFF decoder in this tool's dependencies - and is rejected with an
+// explicit error rather than silently ignored.
+func newDEMSource(path string) (DEMSource, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return newCachedDEMSource(newHTTPDEMSource(path)), nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return newCachedDEMSource(newHGTDEMSource(path)), nil
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".hgt" {
+		return newCachedDEMSource(newHGTDEMSource(filepath.Dir(path))), nil
+	}
+	return nil, fmt.Errorf("terrain: --dem must be an SRTM .hgt file/directory or an http(s) tile service; GeoTIFF is not supported")
+}
+
+// cachedDEMSource memoizes lookups on a ~1km lat/lon cell, since a single
+// horizon sweep repeatedly samples nearby points across many track points.
+type cachedDEMSource struct {
+	source DEMSource
+	cache  map[string]float64
+}
+
+func newCachedDEMSource(source DEMSource) *cachedDEMSource {
+	return &cachedDEMSource{source: source, cache: make(map[string]float64)}
+}
+
+func (c *cachedDEMSource) ElevationAt(lat, lon float64) (float64, error) {
+	key := fmt.Sprintf("%.2f,%.2f", lat, lon)
+	if elevation, ok := c.cache[key]; ok {
+		return elevation, nil
+	}
+	elevation, err := c.source.ElevationAt(lat, lon)
+	if err != nil {
+		return 0, err
+	}
+	c.cache[key] = elevation
+	return elevation, nil
+}
+
+// hgtDEMSource reads SRTM .hgt tiles (big-endian int16 grids, one per
+// whole-degree cell) lazily from a directory, keeping loaded tiles around
+// for the rest of the run.
+type hgtDEMSource struct {
+	dir   string
+	tiles map[string]*hgtTile
+}
+
+type hgtTile struct {
+	size int // samples per side
+	data []int16
+}
+
+func newHGTDEMSource(dir string) *hgtDEMSource {
+	return &hgtDEMSource{dir: dir, tiles: make(map[string]*hgtTile)}
+}
+
+func hgtTileName(lat, lon float64) string {
+	latCell := int(math.Floor(lat))
+	lonCell := int(math.Floor(lon))
+	latPrefix, lonPrefix := "N", "E"
+	if latCell < 0 {
+		latPrefix = "S"
+		latCell = -latCell
+	}
+	if lonCell < 0 {
+		lonPrefix = "W"
+		lonCell = -lonCell
+	}
+	return fmt.Sprintf("%s%02d%s%03d", latPrefix, latCell, lonPrefix, lonCell)
+}
+
+func (d *hgtDEMSource) loadTile(name string) (*hgtTile, error) {
+	if tile, ok := d.tiles[name]; ok {
+		return tile, nil
+	}
+	payload, err := os.ReadFile(filepath.Join(d.dir, name+".hgt"))
+	if err != nil {
+		return nil, err
+	}
+	sampleCount := len(payload) / 2
+	size := int(math.Round(math.Sqrt(float64(sampleCount))))
+	if size*size*2 != len(payload) {
+		return nil, fmt.Errorf("terrain: %s.hgt is not a square SRTM grid", name)
+	}
+	data := make([]int16, sampleCount)
+	for i := range data {
+		data[i] = int16(binary.BigEndian.Uint16(payload[i*2:]))
+	}
+	tile := &hgtTile{size: size, data: data}
+	d.tiles[name] = tile
+	return tile, nil
+}
+
+func (d *hgtDEMSource) ElevationAt(lat, lon float64) (float64, error) {
+	tile, err := d.loadTile(hgtTileName(lat, lon))
+	if err != nil {
+		return 0, err
+	}
+	// SRTM rows run north to south, columns west to east; pick the nearest
+	// sample rather than interpolating
+	row := int(math.Round((1 - (lat - math.Floor(lat))) * float64(tile.size-1)))
+	col := int(math.Round((lon - math.Floor(lon)) * float64(tile.size-1)))
+	row = clampInt(row, 0, tile.size-1)
+	col = clampInt(col, 0, tile.size-1)
+
+	sample := tile.data[row*tile.size+col]
+	if sample == -32768 {
+		return 0, nil // SRTM void value
+	}
+	return float64(sample), nil
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// httpDEMSource queries a simple lat/lon -> elevation HTTP tile service:
+// GET <baseURL>?lat=<lat>&lon=<lon>, body is the elevation in meters.
+type httpDEMSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPDEMSource(baseURL string) *httpDEMSource {
+	return &httpDEMSource{baseURL: baseURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (d *httpDEMSource) ElevationAt(lat, lon float64) (float64, error) {
+	response, err := d.client.Get(fmt.Sprintf("%s?lat=%f&lon=%f", d.baseURL, lat, lon))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	elevation, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("terrain: unexpected response from %s: %w", d.baseURL, err)
+	}
+	return elevation, nil
+}
+
+// offsetLatLon returns the point distanceKm away from (lat, lon) along
+// bearingRad, using the standard great-circle destination formula.
+func offsetLatLon(lat, lon, bearingRad, distanceKm float64) (float64, float64) {
+	angularDistance := distanceKm / earthRadiusKm
+	phi1 := degreesToRadians(lat)
+	lambda1 := degreesToRadians(lon)
+
+	phi2 := math.Asin(math.Sin(phi1)*math.Cos(angularDistance) + math.Cos(phi1)*math.Sin(angularDistance)*math.Cos(bearingRad))
+	lambda2 := lambda1 + math.Atan2(math.Sin(bearingRad)*math.Sin(angularDistance)*math.Cos(phi1), math.Cos(angularDistance)-math.Sin(phi1)*math.Sin(phi2))
+
+	return radiansToDegrees(phi2), radiansToDegrees(lambda2)
+}
+
+// horizonAngleDegrees samples dem along the azimuth to the sun out to
+// horizonSampleMaxKm and returns the steepest elevation angle found - the
+// obstruction that sets the horizon is the one with the largest angle, which
+// can be a close low ridge rather than the tallest distant peak.
+func horizonAngleDegrees(dem DEMSource, lat, lon, pointElevationM, sunAzimuthDegrees float64) (float64, error) {
+	azimuthRad := degreesToRadians(sunAzimuthDegrees)
+	maxAngle := math.Inf(-1)
+	for distanceKm := horizonSampleStepKm; distanceKm <= horizonSampleMaxKm; distanceKm += horizonSampleStepKm {
+		sampleLat, sampleLon := offsetLatLon(lat, lon, azimuthRad, distanceKm)
+		sampleElevationM, err := dem.ElevationAt(sampleLat, sampleLon)
+		if err != nil {
+			return 0, err
+		}
+
+		riseKm := (sampleElevationM - pointElevationM) / 1000
+		curvatureDropKm := curvatureCoefficient * distanceKm * distanceKm / earthRadiusKm
+		angle := radiansToDegrees(math.Atan2(riseKm-curvatureDropKm, distanceKm))
+		if angle > maxAngle {
+			maxAngle = angle
+		}
+	}
+	if math.IsInf(maxAngle, -1) {
+		return 0, nil
+	}
+	return maxAngle, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// flatDEM returns a fixed elevation everywhere, for testing the curvature
+// term in isolation.
+type flatDEM struct {
+	elevationM float64
+}
+
+func (d flatDEM) ElevationAt(lat, lon float64) (float64, error) {
+	return d.elevationM, nil
+}
+
+// ridgeDEM is flat except for a wall of height wallElevationM starting at
+// wallDistanceKm along the sampled azimuth, simulating a single obstruction.
+type ridgeDEM struct {
+	lat, lon        float64
+	wallDistanceKm  float64
+	wallElevationM  float64
+	groundElevation float64
+}
+
+func (d ridgeDEM) ElevationAt(lat, lon float64) (float64, error) {
+	distanceKm := math.Hypot(lat-d.lat, (lon-d.lon)*math.Cos(degreesToRadians(d.lat))) * (earthRadiusKm * math.Pi / 180)
+	if distanceKm >= d.wallDistanceKm {
+		return d.wallElevationM, nil
+	}
+	return d.groundElevation, nil
+}
+
+func TestHorizonAngleDegreesFlatTerrainIsBelowHorizontal(t *testing.T) {
+	// a perfectly flat DEM at the point's own elevation should read as
+	// slightly below 0°, from the Earth-curvature/refraction correction
+	angle, err := horizonAngleDegrees(flatDEM{elevationM: 0}, 45, 0, 0, 90)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if angle >= 0 {
+		t.Errorf("horizonAngleDegrees() over flat terrain = %v, want < 0 (curvature drop)", angle)
+	}
+}
+
+func TestHorizonAngleDegreesTallerRidgeMeansHigherHorizon(t *testing.T) {
+	low, err := horizonAngleDegrees(ridgeDEM{lat: 45, lon: 0, wallDistanceKm: 5, wallElevationM: 200}, 45, 0, 0, 90)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	high, err := horizonAngleDegrees(ridgeDEM{lat: 45, lon: 0, wallDistanceKm: 5, wallElevationM: 2000}, 45, 0, 0, 90)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if high <= low {
+		t.Errorf("horizonAngleDegrees() taller ridge = %v, want > shorter ridge = %v", high, low)
+	}
+}
+
+func TestHorizonAngleDegreesPropagatesSampleError(t *testing.T) {
+	wantErr := errTest{}
+	_, err := horizonAngleDegrees(errDEM{err: wantErr}, 45, 0, 0, 90)
+	if err != wantErr {
+		t.Errorf("horizonAngleDegrees() error = %v, want %v", err, wantErr)
+	}
+}
+
+type errTest struct{}
+
+func (errTest) Error() string { return "dem sample failed" }
+
+type errDEM struct{ err error }
+
+func (d errDEM) ElevationAt(lat, lon float64) (float64, error) {
+	return 0, d.err
+}
+
+func TestOffsetLatLonDueEast(t *testing.T) {
+	lat, lon := offsetLatLon(0, 0, degreesToRadians(90), 111.195) // ~1 degree of longitude at the equator
+	if math.Abs(lat) > 0.01 {
+		t.Errorf("offsetLatLon() due east lat = %v, want ~0", lat)
+	}
+	if math.Abs(lon-1) > 0.01 {
+		t.Errorf("offsetLatLon() due east lon = %v, want ~1", lon)
+	}
+}